@@ -0,0 +1,287 @@
+// Copyright 2023 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coordinator provides a lightweight HTTP service that lets several
+// ct_hammer processes on different hosts hammer the same log(s) together: it
+// hands out a start-of-day barrier so workers begin "Hammer Time" in lockstep,
+// and aggregates per-endpoint counters that workers report back periodically
+// so the fleet's overall progress can be scraped from one place.
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// RegisterRequest is sent by a worker once at startup.
+type RegisterRequest struct {
+	WorkerID    int `json:"worker_id"`
+	WorkerCount int `json:"worker_count"`
+}
+
+// ReportRequest carries one worker's counters for a single log prefix, as a
+// cumulative total since that worker started (not a delta since its last
+// report).
+type ReportRequest struct {
+	WorkerID int                `json:"worker_id"`
+	Prefix   string             `json:"prefix"`
+	Counts   map[string]float64 `json:"counts"`
+}
+
+// PartitionRate splits an aggregate target rate across workerCount workers,
+// giving worker workerID its share. Any remainder (when total doesn't divide
+// evenly) is handed to the lowest-numbered workers, one unit each, so the sum
+// of all workers' shares is always exactly total.
+func PartitionRate(total, workerCount, workerID int) int {
+	if workerCount <= 1 {
+		return total
+	}
+	share := total / workerCount
+	if workerID < total%workerCount {
+		share++
+	}
+	return share
+}
+
+// EntryRangeShard splits the index range [0, treeSize) into workerCount
+// contiguous, roughly-equal shards and returns the [start, end) bounds of the
+// shard owned by workerID. It is used to hand each worker a disjoint slice of
+// a log's entries to fetch via get-entries, so that a coordinated run doesn't
+// waste requests re-reading the same entries from multiple workers.
+func EntryRangeShard(treeSize int64, workerCount, workerID int) (start, end int64) {
+	if workerCount <= 1 {
+		return 0, treeSize
+	}
+	share := treeSize / int64(workerCount)
+	rem := treeSize % int64(workerCount)
+
+	start = int64(workerID) * share
+	if int64(workerID) < rem {
+		start += int64(workerID)
+	} else {
+		start += rem
+	}
+	end = start + share
+	if int64(workerID) < rem {
+		end++
+	}
+	return start, end
+}
+
+// Server is the coordinator-side HTTP service. Run it on one host and point
+// every worker's --coordinator_addr at it.
+type Server struct {
+	mu          sync.Mutex
+	workerCount int
+	registered  map[int]bool
+
+	// reports holds, per log prefix and worker ID, that worker's latest
+	// reported counters. Since each report is a cumulative total rather
+	// than a delta, a new report from a worker replaces its previous one
+	// rather than adding to it; handleMetrics sums across workers.
+	reports map[string]map[int]map[string]float64 // prefix -> worker ID -> metric name -> value
+}
+
+// NewServer returns a Server that will release its barrier once workerCount
+// distinct workers have registered.
+func NewServer(workerCount int) *Server {
+	return &Server{
+		workerCount: workerCount,
+		registered:  make(map[int]bool),
+		reports:     make(map[string]map[int]map[string]float64),
+	}
+}
+
+// Handler returns the http.Handler to serve, e.g. via http.ListenAndServe.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/coordinator/register", s.handleRegister)
+	mux.HandleFunc("/coordinator/barrier", s.handleBarrier)
+	mux.HandleFunc("/coordinator/report", s.handleReport)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return mux
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid register request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.WorkerCount != s.workerCount {
+		http.Error(w, fmt.Sprintf("worker_count %d does not match coordinator's %d", req.WorkerCount, s.workerCount), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.registered[req.WorkerID] = true
+	n := len(s.registered)
+	s.mu.Unlock()
+
+	glog.Infof("coordinator: worker %d registered (%d/%d)", req.WorkerID, n, s.workerCount)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleBarrier(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	ready := len(s.registered) >= s.workerCount
+	s.mu.Unlock()
+
+	if !ready {
+		http.Error(w, "not all workers have registered yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	var req ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid report request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	m, ok := s.reports[req.Prefix]
+	if !ok {
+		m = make(map[int]map[string]float64)
+		s.reports[req.Prefix] = m
+	}
+	m[req.WorkerID] = req.Counts
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleMetrics renders the aggregated, fleet-wide counters in the
+// Prometheus text exposition format so the coordinator's /metrics endpoint
+// can be scraped in place of each worker's own.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	prefixes := make([]string, 0, len(s.reports))
+	for prefix := range s.reports {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		totals := make(map[string]float64)
+		for _, counts := range s.reports[prefix] {
+			for name, val := range counts {
+				totals[name] += val
+			}
+		}
+		names := make([]string, 0, len(totals))
+		for name := range totals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&buf, "ct_hammer_%s{log_prefix=%q} %v\n", name, prefix, totals[name])
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// Client is the worker-side handle used to talk to a coordinator Server.
+type Client struct {
+	addr        string
+	workerID    int
+	workerCount int
+	httpClient  *http.Client
+}
+
+// NewClient returns a Client that will identify itself as workerID out of
+// workerCount when talking to the coordinator at addr.
+func NewClient(addr string, workerID, workerCount int) *Client {
+	return &Client{
+		addr:        addr,
+		workerID:    workerID,
+		workerCount: workerCount,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) post(ctx context.Context, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("coordinator: failed to marshal request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+c.addr+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("coordinator: failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("coordinator: request to %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("coordinator: %s returned status %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// Register tells the coordinator this worker is up.
+func (c *Client) Register(ctx context.Context) error {
+	return c.post(ctx, "/coordinator/register", RegisterRequest{WorkerID: c.workerID, WorkerCount: c.workerCount})
+}
+
+// WaitBarrier blocks, polling the coordinator at pollInterval, until every
+// worker in the fleet has registered. It returns an error if ctx is done
+// first.
+func (c *Client) WaitBarrier(ctx context.Context, pollInterval time.Duration) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+c.addr+"/coordinator/barrier", nil)
+	if err != nil {
+		return fmt.Errorf("coordinator: failed to build barrier request: %v", err)
+	}
+
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for {
+		resp, err := c.httpClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("coordinator: timed out waiting for barrier: %v", ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// ReportCounts sends this worker's accumulated counters for prefix to the
+// coordinator, to be merged into the fleet-wide totals.
+func (c *Client) ReportCounts(ctx context.Context, prefix string, counts map[string]float64) error {
+	return c.post(ctx, "/coordinator/report", ReportRequest{WorkerID: c.workerID, Prefix: prefix, Counts: counts})
+}
@@ -0,0 +1,171 @@
+// Copyright 2023 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coordinator
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartitionRate(t *testing.T) {
+	tests := []struct {
+		total, workerCount int
+		want               []int
+	}{
+		{total: 100, workerCount: 1, want: []int{100}},
+		{total: 100, workerCount: 4, want: []int{25, 25, 25, 25}},
+		{total: 10, workerCount: 3, want: []int{4, 3, 3}},
+		{total: 0, workerCount: 4, want: []int{0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		var got, sum int
+		for id := 0; id < test.workerCount; id++ {
+			share := PartitionRate(test.total, test.workerCount, id)
+			if share != test.want[id] {
+				t.Errorf("PartitionRate(%d, %d, %d) = %d, want %d", test.total, test.workerCount, id, share, test.want[id])
+			}
+			sum += share
+			got = share
+		}
+		_ = got
+		if sum != test.total {
+			t.Errorf("shares for total=%d workerCount=%d summed to %d, want %d", test.total, test.workerCount, sum, test.total)
+		}
+	}
+}
+
+func TestEntryRangeShard(t *testing.T) {
+	tests := []struct {
+		treeSize    int64
+		workerCount int
+	}{
+		{treeSize: 1000, workerCount: 1},
+		{treeSize: 1000, workerCount: 4},
+		{treeSize: 10, workerCount: 3},
+		{treeSize: 0, workerCount: 4},
+	}
+	for _, test := range tests {
+		var prevEnd int64
+		for id := 0; id < test.workerCount; id++ {
+			start, end := EntryRangeShard(test.treeSize, test.workerCount, id)
+			if start != prevEnd {
+				t.Errorf("treeSize=%d workerCount=%d worker %d: start=%d, want contiguous with previous end %d", test.treeSize, test.workerCount, id, start, prevEnd)
+			}
+			if end < start {
+				t.Errorf("treeSize=%d workerCount=%d worker %d: end %d < start %d", test.treeSize, test.workerCount, id, end, start)
+			}
+			prevEnd = end
+		}
+		if prevEnd != test.treeSize {
+			t.Errorf("treeSize=%d workerCount=%d: shards covered up to %d, want %d", test.treeSize, test.workerCount, prevEnd, test.treeSize)
+		}
+	}
+}
+
+func TestBarrierReleasesOnceAllWorkersRegister(t *testing.T) {
+	const workerCount = 3
+	srv := NewServer(workerCount)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	clients := make([]*Client, workerCount)
+	for i := range clients {
+		clients[i] = NewClient(addr, i, workerCount)
+	}
+
+	// Only two of three workers have registered: the barrier must not release.
+	for i := 0; i < workerCount-1; i++ {
+		if err := clients[i].Register(ctx); err != nil {
+			t.Fatalf("Register(%d) = %v", i, err)
+		}
+	}
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	if err := clients[0].WaitBarrier(shortCtx, 20*time.Millisecond); err == nil {
+		t.Fatalf("WaitBarrier() succeeded with only %d/%d workers registered", workerCount-1, workerCount)
+	}
+
+	if err := clients[workerCount-1].Register(ctx); err != nil {
+		t.Fatalf("Register(%d) = %v", workerCount-1, err)
+	}
+	if err := clients[0].WaitBarrier(ctx, 20*time.Millisecond); err != nil {
+		t.Errorf("WaitBarrier() = %v, want nil once all workers registered", err)
+	}
+}
+
+func TestReportCountsAggregatesAcrossWorkers(t *testing.T) {
+	srv := NewServer(2)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	ctx := context.Background()
+
+	c0 := NewClient(addr, 0, 2)
+	c1 := NewClient(addr, 1, 2)
+	if err := c0.ReportCounts(ctx, "log1", map[string]float64{"get_sth": 3}); err != nil {
+		t.Fatalf("ReportCounts(0) = %v", err)
+	}
+	if err := c1.ReportCounts(ctx, "log1", map[string]float64{"get_sth": 4}); err != nil {
+		t.Fatalf("ReportCounts(1) = %v", err)
+	}
+
+	if got, want := aggregatedCount(srv, "log1", "get_sth"), float64(7); got != want {
+		t.Errorf("aggregated get_sth count = %v, want %v", got, want)
+	}
+}
+
+// TestReportCountsReplacesPriorReportFromSameWorker guards against each
+// worker's cumulative-since-start report being double-counted: a worker
+// reports its counters periodically, and each report already includes
+// everything from every prior report, so a later report must replace
+// rather than add to the earlier one.
+func TestReportCountsReplacesPriorReportFromSameWorker(t *testing.T) {
+	srv := NewServer(1)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	ctx := context.Background()
+	c := NewClient(addr, 0, 1)
+
+	if err := c.ReportCounts(ctx, "log1", map[string]float64{"get_sth": 5}); err != nil {
+		t.Fatalf("ReportCounts() = %v", err)
+	}
+	if err := c.ReportCounts(ctx, "log1", map[string]float64{"get_sth": 12}); err != nil {
+		t.Fatalf("ReportCounts() = %v", err)
+	}
+
+	if got, want := aggregatedCount(srv, "log1", "get_sth"), float64(12); got != want {
+		t.Errorf("aggregated get_sth count after two reports = %v, want %v", got, want)
+	}
+}
+
+func aggregatedCount(srv *Server, prefix, name string) float64 {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var total float64
+	for _, counts := range srv.reports[prefix] {
+		total += counts[name]
+	}
+	return total
+}
@@ -18,6 +18,7 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto"
 	"encoding/base64"
 	"flag"
@@ -35,6 +36,9 @@ import (
 	"github.com/google/certificate-transparency-go/trillian/ctfe"
 	"github.com/google/certificate-transparency-go/trillian/ctfe/configpb"
 	"github.com/google/certificate-transparency-go/trillian/integration"
+	"github.com/google/certificate-transparency-go/trillian/integration/ct_hammer/coordinator"
+	"github.com/google/certificate-transparency-go/trillian/integration/ct_hammer/report"
+	"github.com/google/certificate-transparency-go/trillian/integration/ct_hammer/statsd"
 	"github.com/google/trillian/monitoring"
 	"github.com/google/trillian/monitoring/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -48,6 +52,9 @@ var (
 	testDir             = flag.String("testdata_dir", "testdata", "Name of directory with test data")
 	leafNotAfter        = flag.String("leaf_not_after", "", "Not-After date to use for leaf certs, RFC3339/ISO-8601 format (e.g. 2017-11-26T12:29:19Z)")
 	metricsEndpoint     = flag.String("metrics_endpoint", "", "Endpoint for serving metrics; if left empty, metrics will not be exposed")
+	metricsBackend      = flag.String("metrics_backend", "prom", "Metrics backend to use: prom, statsd, or none")
+	statsdAddr          = flag.String("statsd_addr", "127.0.0.1:8125", "Address of the StatsD/DogStatsD agent to send metrics to, when --metrics_backend=statsd")
+	statsdPrefix        = flag.String("statsd_prefix", "ct_hammer.", "Prefix prepended to all metric names, when --metrics_backend=statsd")
 	seed                = flag.Int64("seed", -1, "Seed for random number generation")
 	logConfig           = flag.String("log_config", "", "File holding log config in JSON")
 	mmd                 = flag.Duration("mmd", 2*time.Minute, "Default MMD for logs")
@@ -59,7 +66,22 @@ var (
 	limit               = flag.Int("rate_limit", 0, "Maximum rate of requests to an individual log; 0 for no rate limit")
 	ignoreErrors        = flag.Bool("ignore_errors", false, "Whether to ignore errors and retry the operation")
 	maxRetry            = flag.Duration("max_retry", 60*time.Second, "How long to keep retrying when ignore_errors is set")
+
+	reportFile     = flag.String("report_file", "", "If set, write a machine-readable NDJSON run report to this file on completion")
+	reportFailures = flag.Bool("report_failures", false, "Whether to also write one NDJSON line per failed operation to --report_file")
+
+	coordinatorListen = flag.String("coordinator_listen", "", "If set, run as the coordinator for a distributed hammer run, serving on this address:port, instead of hammering a log")
+	coordinatorAddr   = flag.String("coordinator_addr", "", "Address:port of the coordinator to synchronize with, for a distributed hammer run; leave empty to run standalone")
+	workerID          = flag.Int("worker_id", 0, "Index of this worker within the fleet, in [0, worker_count); only used with --coordinator_addr")
+	workerCount       = flag.Int("worker_count", 1, "Total number of workers in the fleet: with --coordinator_addr, used to partition --rate_limit and get-entries ranges; with --coordinator_listen, the number of workers the coordinator waits for at its barrier")
 )
+
+// coordinatorReportInterval is how often a worker relays its live
+// per-endpoint counts and latencies to the coordinator while hammering, so
+// the coordinator's /metrics endpoint reflects in-progress runs rather than
+// only the end-of-run result.
+const coordinatorReportInterval = 5 * time.Second
+
 var (
 	addChainBias          = flag.Int("add_chain", 20, "Bias for add-chain operations")
 	addPreChainBias       = flag.Int("add_pre_chain", 20, "Bias for add-pre-chain operations")
@@ -79,8 +101,38 @@ func newLimiter(rate int) integration.Limiter {
 	return ratelimiter.NewLimiter(rate)
 }
 
+// runCoordinator serves the coordination endpoints used to synchronize a
+// distributed hammer run and blocks forever; it never returns.
+func runCoordinator(listenAddr string, workerCount int) {
+	srv := coordinator.NewServer(workerCount)
+	glog.Infof("Serving hammer coordinator for %d workers at %v", workerCount, listenAddr)
+	glog.Exitf("Coordinator exited: %v", http.ListenAndServe(listenAddr, srv.Handler()))
+}
+
+// reportStatsToCoordinator relays stats' live per-endpoint counts and
+// latencies for prefix to coord every coordinatorReportInterval, so the
+// coordinator's /metrics endpoint reflects an in-progress run rather than
+// only the final result. It returns once done is closed.
+func reportStatsToCoordinator(coord *coordinator.Client, prefix string, stats *integration.Stats, done <-chan struct{}) {
+	t := time.NewTicker(coordinatorReportInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := coord.ReportCounts(context.Background(), prefix, stats.Snapshot()); err != nil {
+				glog.Warningf("Failed to report counts to coordinator: %v", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
 func main() {
 	flag.Parse()
+	if *coordinatorListen != "" {
+		runCoordinator(*coordinatorListen, *workerCount)
+	}
 	if *logConfig == "" {
 		glog.Exit("Test aborted as no log config provided (via --log_config)")
 	}
@@ -145,17 +197,60 @@ func main() {
 	}
 
 	var mf monitoring.MetricFactory
-	if *metricsEndpoint != "" {
+	switch *metricsBackend {
+	case "prom":
 		mf = prometheus.MetricFactory{}
-		http.Handle("/metrics", promhttp.Handler())
-		server := http.Server{Addr: *metricsEndpoint, Handler: nil}
-		glog.Infof("Serving metrics at %v", *metricsEndpoint)
-		go func() {
-			err := server.ListenAndServe()
-			glog.Warningf("Metrics server exited: %v", err)
-		}()
-	} else {
+		if *metricsEndpoint != "" {
+			http.Handle("/metrics", promhttp.Handler())
+			server := http.Server{Addr: *metricsEndpoint, Handler: nil}
+			glog.Infof("Serving metrics at %v", *metricsEndpoint)
+			go func() {
+				err := server.ListenAndServe()
+				glog.Warningf("Metrics server exited: %v", err)
+			}()
+		}
+	case "statsd":
+		sf, err := statsd.NewMetricFactory(*statsdAddr, *statsdPrefix)
+		if err != nil {
+			glog.Exitf("Failed to create statsd metric factory: %v", err)
+		}
+		defer sf.Close()
+		mf = sf
+		glog.Infof("Sending metrics to statsd agent at %v", *statsdAddr)
+	case "none":
 		mf = monitoring.InertMetricFactory{}
+	default:
+		glog.Exitf("Unknown --metrics_backend %q; want one of prom, statsd, none", *metricsBackend)
+	}
+
+	var reportSink integration.ReportSink
+	if *reportFile != "" {
+		sink, err := report.NewNDJSONSink(*reportFile, *reportFailures)
+		if err != nil {
+			glog.Exitf("Failed to create report file: %v", err)
+		}
+		defer sink.Close()
+		reportSink = sink
+		glog.Infof("Writing run report to %v", *reportFile)
+	}
+
+	rateLimit := *limit
+	var coord *coordinator.Client
+	var entryRangeShard func(treeSize int64) (start, end int64)
+	if *coordinatorAddr != "" {
+		coord = coordinator.NewClient(*coordinatorAddr, *workerID, *workerCount)
+		ctx := context.Background()
+		if err := coord.Register(ctx); err != nil {
+			glog.Exitf("Failed to register with coordinator at %v: %v", *coordinatorAddr, err)
+		}
+		rateLimit = coordinator.PartitionRate(*limit, *workerCount, *workerID)
+		entryRangeShard = func(treeSize int64) (int64, int64) {
+			return coordinator.EntryRangeShard(treeSize, *workerCount, *workerID)
+		}
+		glog.Infof("Worker %d/%d waiting at barrier before hammering; this worker's share of --rate_limit is %d", *workerID, *workerCount, rateLimit)
+		if err := coord.WaitBarrier(ctx, time.Second); err != nil {
+			glog.Exitf("Failed waiting at coordinator barrier: %v", err)
+		}
 	}
 
 	if *banner {
@@ -205,6 +300,7 @@ func main() {
 			glog.Exitf("Failed to build chain generator: %v", err)
 		}
 
+		stats := integration.NewStats()
 		cfg := integration.HammerConfig{
 			LogCfg:              c,
 			MetricFactory:       mf,
@@ -216,14 +312,30 @@ func main() {
 			MaxGetEntries:       *maxGetEntries,
 			OversizedGetEntries: *oversizedGetEntries,
 			Operations:          *operations,
-			Limiter:             newLimiter(*limit),
+			Limiter:             newLimiter(rateLimit),
 			MaxParallelChains:   *maxParallelChains,
 			IgnoreErrors:        *ignoreErrors,
 			MaxRetryDuration:    *maxRetry,
+			WorkerID:            *workerID,
+			WorkerCount:         *workerCount,
+			Seed:                *seed,
+			ReportSink:          reportSink,
+			Stats:               stats,
+			EntryRangeShard:     entryRangeShard,
 		}
 		go func(cfg integration.HammerConfig) {
 			defer wg.Done()
+			done := make(chan struct{})
+			if coord != nil {
+				go reportStatsToCoordinator(coord, cfg.LogCfg.Prefix, stats, done)
+			}
 			err := integration.HammerCTLog(cfg)
+			close(done)
+			if coord != nil {
+				if rerr := coord.ReportCounts(context.Background(), cfg.LogCfg.Prefix, stats.Snapshot()); rerr != nil {
+					glog.Warningf("Failed to report final counts to coordinator: %v", rerr)
+				}
+			}
 			results <- result{prefix: cfg.LogCfg.Prefix, err: err}
 		}(cfg)
 	}
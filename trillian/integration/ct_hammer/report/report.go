@@ -0,0 +1,231 @@
+// Copyright 2023 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package report implements a structured NDJSON run-report sink for
+// ct_hammer. Unlike glog output and Prometheus/StatsD counters, which only
+// show live or point-in-time state, a report file is a stable artifact that
+// can be diffed between runs or ingested by a CI dashboard.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/trillian/integration"
+)
+
+// Percentiles holds latency percentiles, in milliseconds, observed for one
+// endpoint.
+type Percentiles struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P99 float64 `json:"p99_ms"`
+}
+
+// LogReport is the NDJSON record written once per log prefix on completion.
+type LogReport struct {
+	Prefix      string                 `json:"prefix"`
+	Seed        int64                  `json:"seed"`
+	StartTime   time.Time              `json:"start_time"`
+	EndTime     time.Time              `json:"end_time"`
+	Bias        map[string]int         `json:"bias"`
+	Counts      map[string]int64       `json:"counts"`
+	ErrorCounts map[string]int64       `json:"error_counts"`
+	Latencies   map[string]Percentiles `json:"latencies"`
+	Retries     int64                  `json:"retries"`
+	STH         *integration.STH       `json:"sth,omitempty"`
+}
+
+// Failure is the NDJSON record written for one failed operation, when
+// enabled via --report_failures.
+type Failure struct {
+	Prefix   string            `json:"prefix"`
+	Time     time.Time         `json:"time"`
+	Endpoint string            `json:"endpoint"`
+	Params   map[string]string `json:"params,omitempty"`
+	Error    string            `json:"error"`
+}
+
+// httpStatusError is implemented by errors that carry an HTTP status code,
+// such as those returned by the CT client; when an operation's error
+// implements it, the status is used to key error_counts instead of the
+// generic error string, so e.g. every 429 groups together.
+type httpStatusError interface {
+	StatusCode() int
+}
+
+func errorKey(err error) string {
+	if hse, ok := err.(httpStatusError); ok {
+		return fmt.Sprintf("http_%d", hse.StatusCode())
+	}
+	return err.Error()
+}
+
+type logStats struct {
+	counts      map[string]int64
+	errorCounts map[string]int64
+	latencies   map[string][]time.Duration
+	retries     int64
+}
+
+func newLogStats() *logStats {
+	return &logStats{
+		counts:      make(map[string]int64),
+		errorCounts: make(map[string]int64),
+		latencies:   make(map[string][]time.Duration),
+	}
+}
+
+// NDJSONSink is an integration.ReportSink that writes one NDJSON line per
+// LogReport (and, when enabled, one NDJSON line per Failure) to a single
+// report file shared across every log a hammer run is driving.
+type NDJSONSink struct {
+	reportFailures bool
+
+	mu    sync.Mutex
+	f     *os.File
+	stats map[string]*logStats
+}
+
+var _ integration.ReportSink = (*NDJSONSink)(nil)
+
+// NewNDJSONSink creates (truncating any existing contents of) the file at
+// path and returns an integration.ReportSink that writes NDJSON records to
+// it. When reportFailures is true, RecordOp also writes a Failure record
+// for every non-nil err it's given.
+func NewNDJSONSink(path string, reportFailures bool) (*NDJSONSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("report: failed to create %q: %v", path, err)
+	}
+	return &NDJSONSink{
+		reportFailures: reportFailures,
+		f:              f,
+		stats:          make(map[string]*logStats),
+	}, nil
+}
+
+func (s *NDJSONSink) statsFor(prefix string) *logStats {
+	st, ok := s.stats[prefix]
+	if !ok {
+		st = newLogStats()
+		s.stats[prefix] = st
+	}
+	return st
+}
+
+func (s *NDJSONSink) writeLine(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("report: failed to marshal record: %v", err)
+	}
+	data = append(data, '\n')
+	_, err = s.f.Write(data)
+	return err
+}
+
+// RecordOp implements integration.ReportSink.
+func (s *NDJSONSink) RecordOp(prefix, endpoint string, latency time.Duration, err error, params map[string]string) {
+	s.mu.Lock()
+	st := s.statsFor(prefix)
+	st.counts[endpoint]++
+	st.latencies[endpoint] = append(st.latencies[endpoint], latency)
+	if err != nil {
+		st.errorCounts[errorKey(err)]++
+	}
+	s.mu.Unlock()
+
+	if err != nil && s.reportFailures {
+		failure := Failure{
+			Prefix:   prefix,
+			Time:     time.Now(),
+			Endpoint: endpoint,
+			Params:   params,
+			Error:    err.Error(),
+		}
+		s.mu.Lock()
+		werr := s.writeLine(failure)
+		s.mu.Unlock()
+		if werr != nil {
+			glog.Warningf("report: failed to write failure record for %s/%s: %v", prefix, endpoint, werr)
+		}
+	}
+}
+
+// RecordRetry implements integration.ReportSink.
+func (s *NDJSONSink) RecordRetry(prefix, endpoint string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statsFor(prefix).retries++
+}
+
+// Finish implements integration.ReportSink.
+func (s *NDJSONSink) Finish(prefix string, summary integration.LogRunSummary) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.statsFor(prefix)
+	latencies := make(map[string]Percentiles, len(st.latencies))
+	for endpoint, vals := range st.latencies {
+		latencies[endpoint] = percentiles(vals)
+	}
+	delete(s.stats, prefix)
+
+	return s.writeLine(LogReport{
+		Prefix:      prefix,
+		Seed:        summary.Seed,
+		StartTime:   summary.StartTime,
+		EndTime:     time.Now(),
+		Bias:        summary.Bias,
+		Counts:      st.counts,
+		ErrorCounts: st.errorCounts,
+		Latencies:   latencies,
+		Retries:     st.retries,
+		STH:         summary.STH,
+	})
+}
+
+// Close implements integration.ReportSink.
+func (s *NDJSONSink) Close() error {
+	return s.f.Close()
+}
+
+func percentiles(latencies []time.Duration) Percentiles {
+	if len(latencies) == 0 {
+		return Percentiles{}
+	}
+	ms := make([]float64, len(latencies))
+	for i, l := range latencies {
+		ms[i] = float64(l) / float64(time.Millisecond)
+	}
+	sort.Float64s(ms)
+	return Percentiles{
+		P50: percentile(ms, 0.50),
+		P90: percentile(ms, 0.90),
+		P99: percentile(ms, 0.99),
+	}
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/trillian/integration"
+)
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %q: %v", path, err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestFinishWritesLogReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.ndjson")
+	sink, err := NewNDJSONSink(path, false)
+	if err != nil {
+		t.Fatalf("NewNDJSONSink() = %v", err)
+	}
+
+	sink.RecordOp("log1", "get-sth", 10*time.Millisecond, nil, nil)
+	sink.RecordOp("log1", "get-sth", 20*time.Millisecond, nil, nil)
+	sink.RecordOp("log1", "add-chain", 5*time.Millisecond, errors.New("boom"), nil)
+	sink.RecordRetry("log1", "add-chain")
+
+	if err := sink.Finish("log1", integration.LogRunSummary{
+		Seed:      42,
+		StartTime: time.Unix(1000, 0),
+		Bias:      map[string]int{"add-chain": 20},
+		STH:       &integration.STH{TreeSize: 7, RootHash: "abcd", Timestamp: 1234},
+	}); err != nil {
+		t.Fatalf("Finish() = %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	lines := readLines(t, path)
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(lines))
+	}
+
+	var got LogReport
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("failed to unmarshal report line: %v", err)
+	}
+	if got.Prefix != "log1" || got.Seed != 42 {
+		t.Errorf("got prefix=%q seed=%d, want prefix=log1 seed=42", got.Prefix, got.Seed)
+	}
+	if got.Counts["get-sth"] != 2 {
+		t.Errorf("got Counts[get-sth]=%d, want 2", got.Counts["get-sth"])
+	}
+	if got.ErrorCounts["boom"] != 1 {
+		t.Errorf("got ErrorCounts[boom]=%d, want 1", got.ErrorCounts["boom"])
+	}
+	if got.Retries != 1 {
+		t.Errorf("got Retries=%d, want 1", got.Retries)
+	}
+	if got.STH == nil || got.STH.TreeSize != 7 {
+		t.Errorf("got STH=%+v, want TreeSize=7", got.STH)
+	}
+	if p := got.Latencies["get-sth"]; p.P50 == 0 {
+		t.Errorf("got Latencies[get-sth].P50=0, want non-zero")
+	}
+}
+
+func TestRecordOpWritesFailureWhenEnabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.ndjson")
+	sink, err := NewNDJSONSink(path, true)
+	if err != nil {
+		t.Fatalf("NewNDJSONSink() = %v", err)
+	}
+	defer sink.Close()
+
+	sink.RecordOp("log1", "get-entries", time.Millisecond, errors.New("rate limited"), map[string]string{"start": "0", "end": "10"})
+	sink.RecordOp("log1", "get-sth", time.Millisecond, nil, nil)
+
+	lines := readLines(t, path)
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1 (only the failed op)", len(lines))
+	}
+
+	var got Failure
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("failed to unmarshal failure line: %v", err)
+	}
+	if got.Endpoint != "get-entries" || got.Error != "rate limited" || got.Params["start"] != "0" {
+		t.Errorf("got %+v, want endpoint=get-entries error=\"rate limited\" params[start]=0", got)
+	}
+}
+
+func TestErrorKeyUsesHTTPStatusWhenAvailable(t *testing.T) {
+	if got, want := errorKey(statusErr{code: 429}), "http_429"; got != want {
+		t.Errorf("errorKey(statusErr{429}) = %q, want %q", got, want)
+	}
+	if got, want := errorKey(errors.New("oops")), "oops"; got != want {
+		t.Errorf("errorKey(plain error) = %q, want %q", got, want)
+	}
+}
+
+type statusErr struct{ code int }
+
+func (e statusErr) Error() string   { return "http error" }
+func (e statusErr) StatusCode() int { return e.code }
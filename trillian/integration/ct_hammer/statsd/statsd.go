@@ -0,0 +1,302 @@
+// Copyright 2023 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package statsd provides a trillian/monitoring MetricFactory that emits
+// metrics to a DogStatsD-compatible StatsD agent over UDP, as an alternative
+// to scraping a per-instance Prometheus endpoint.
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian/monitoring"
+)
+
+const (
+	// flushInterval bounds how long a metric can sit in the send buffer
+	// before being flushed to the agent.
+	flushInterval = 300 * time.Millisecond
+	// maxPacketSize keeps buffered UDP datagrams under the usual safe MTU.
+	maxPacketSize = 1400
+)
+
+// Factory is a monitoring.MetricFactory that reports metrics to a StatsD (or
+// DogStatsD) agent rather than exposing them for scraping. It is intended
+// for hammer deployments that already run a statsd/Datadog agent sidecar.
+type Factory struct {
+	client *client
+	prefix string
+}
+
+// NewMetricFactory dials addr (host:port of a StatsD/DogStatsD agent, UDP)
+// and returns a Factory whose metric names are all prepended with prefix.
+// The returned Factory buffers sends and flushes them from a background
+// goroutine; callers must call Close when done to drain the buffer.
+func NewMetricFactory(addr, prefix string) (*Factory, error) {
+	c, err := newClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Factory{client: c, prefix: prefix}, nil
+}
+
+// Close flushes any buffered metrics and stops the background flusher.
+func (f *Factory) Close() error {
+	return f.client.Close()
+}
+
+// NewCounter implements monitoring.MetricFactory.
+func (f *Factory) NewCounter(name, help string, labelNames ...string) monitoring.Counter {
+	return &counter{metric: newMetric(f.client, f.prefix+name, labelNames)}
+}
+
+// NewGauge implements monitoring.MetricFactory.
+func (f *Factory) NewGauge(name, help string, labelNames ...string) monitoring.Gauge {
+	return &gauge{metric: newMetric(f.client, f.prefix+name, labelNames)}
+}
+
+// NewHistogram implements monitoring.MetricFactory.
+func (f *Factory) NewHistogram(name, help string, labelNames ...string) monitoring.Histogram {
+	return newHistogram(f.client, f.prefix+name, labelNames)
+}
+
+// NewHistogramWithBuckets implements monitoring.MetricFactory. The buckets
+// are ignored: a StatsD "h" metric carries one observation per send and
+// relies on the agent to aggregate percentiles server-side, rather than on
+// client-chosen bucket boundaries.
+func (f *Factory) NewHistogramWithBuckets(name, help string, buckets []float64, labelNames ...string) monitoring.Histogram {
+	return newHistogram(f.client, f.prefix+name, labelNames)
+}
+
+// metric holds the bits common to counters, gauges and histograms: where to
+// send datapoints, the (already-prefixed) metric name, and a local cache of
+// last-known values so Value() can be answered without a round trip.
+type metric struct {
+	client     *client
+	name       string
+	labelNames []string
+
+	mu   sync.Mutex
+	vals map[string]float64
+}
+
+func newMetric(c *client, name string, labelNames []string) metric {
+	return metric{client: c, name: name, labelNames: labelNames, vals: make(map[string]float64)}
+}
+
+func (m *metric) key(labelvals []string) string {
+	return strings.Join(labelvals, "\x00")
+}
+
+func (m *metric) set(labelvals []string, val float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.vals[m.key(labelvals)] = val
+}
+
+func (m *metric) add(labelvals []string, delta float64) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := m.key(labelvals)
+	m.vals[k] += delta
+	return m.vals[k]
+}
+
+func (m *metric) value(labelvals []string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.vals[m.key(labelvals)]
+}
+
+func (m *metric) send(statsdType string, val float64, labelvals []string) {
+	m.client.send(fmt.Sprintf("%s:%v|%s%s", m.name, val, statsdType, tagSuffix(m.labelNames, labelvals)))
+}
+
+// tagSuffix renders labelNames/labelvals in DogStatsD's trailing "|#k:v,k:v"
+// tag form, or "" if there are no labels.
+func tagSuffix(labelNames, labelvals []string) string {
+	if len(labelNames) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(labelNames))
+	for i, n := range labelNames {
+		v := ""
+		if i < len(labelvals) {
+			v = labelvals[i]
+		}
+		tags = append(tags, n+":"+v)
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+// counter implements monitoring.Counter on top of a StatsD "c" metric.
+type counter struct {
+	metric
+}
+
+func (c *counter) Inc(labelvals ...string) {
+	c.Add(1, labelvals...)
+}
+
+func (c *counter) Add(val float64, labelvals ...string) {
+	c.metric.add(labelvals, val)
+	c.send("c", val, labelvals)
+}
+
+func (c *counter) Value(labelvals ...string) float64 {
+	return c.metric.value(labelvals)
+}
+
+// gauge implements monitoring.Gauge on top of a StatsD "g" metric.
+type gauge struct {
+	metric
+}
+
+func (g *gauge) Inc(labelvals ...string) {
+	g.Add(1, labelvals...)
+}
+
+func (g *gauge) Dec(labelvals ...string) {
+	g.Add(-1, labelvals...)
+}
+
+func (g *gauge) Add(val float64, labelvals ...string) {
+	newVal := g.metric.add(labelvals, val)
+	g.send("g", newVal, labelvals)
+}
+
+func (g *gauge) Set(val float64, labelvals ...string) {
+	g.metric.set(labelvals, val)
+	g.send("g", val, labelvals)
+}
+
+func (g *gauge) Value(labelvals ...string) float64 {
+	return g.metric.value(labelvals)
+}
+
+// histogram implements monitoring.Histogram on top of a StatsD "h" (histogram)
+// metric; DogStatsD agents aggregate percentiles for these server-side. It
+// separately tracks its own count/sum per label set so Info can answer
+// without a round trip to the agent.
+type histogram struct {
+	metric
+
+	mu     sync.Mutex
+	counts map[string]uint64
+	sums   map[string]float64
+}
+
+func newHistogram(c *client, name string, labelNames []string) *histogram {
+	return &histogram{
+		metric: newMetric(c, name, labelNames),
+		counts: make(map[string]uint64),
+		sums:   make(map[string]float64),
+	}
+}
+
+func (h *histogram) Observe(val float64, labelvals ...string) {
+	k := h.metric.key(labelvals)
+	h.mu.Lock()
+	h.counts[k]++
+	h.sums[k] += val
+	h.mu.Unlock()
+	h.send("h", val, labelvals)
+}
+
+// Info implements monitoring.Histogram.
+func (h *histogram) Info(labelvals ...string) (uint64, float64) {
+	k := h.metric.key(labelvals)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.counts[k], h.sums[k]
+}
+
+// client buffers DogStatsD lines and flushes them to addr over UDP, either
+// when the buffer is full or on a fixed interval, whichever comes first.
+type client struct {
+	conn net.Conn
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newClient(addr string) (*client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: failed to dial %q: %v", addr, err)
+	}
+	c := &client{conn: conn, done: make(chan struct{})}
+	c.wg.Add(1)
+	go c.flushLoop()
+	return c, nil
+}
+
+func (c *client) flushLoop() {
+	defer c.wg.Done()
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.flush()
+		case <-c.done:
+			c.flush()
+			return
+		}
+	}
+}
+
+func (c *client) send(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.buf.Len() > 0 && c.buf.Len()+len(line)+1 > maxPacketSize {
+		c.flushLocked()
+	}
+	if c.buf.Len() > 0 {
+		c.buf.WriteByte('\n')
+	}
+	c.buf.WriteString(line)
+}
+
+func (c *client) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+}
+
+func (c *client) flushLocked() {
+	if c.buf.Len() == 0 {
+		return
+	}
+	if _, err := c.conn.Write(c.buf.Bytes()); err != nil {
+		glog.Warningf("statsd: failed to send metrics to agent: %v", err)
+	}
+	c.buf.Reset()
+}
+
+// Close drains the flusher goroutine and closes the underlying UDP socket.
+func (c *client) Close() error {
+	close(c.done)
+	c.wg.Wait()
+	return c.conn.Close()
+}
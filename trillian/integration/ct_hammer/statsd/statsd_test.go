@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readPacket reads a single UDP datagram received on conn, failing the test
+// if none arrives within the timeout.
+func readPacket(t *testing.T, conn *net.UDPConn) string {
+	t.Helper()
+	buf := make([]byte, maxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read UDP packet: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func newTestServer(t *testing.T) (*net.UDPConn, string) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return conn, conn.LocalAddr().String()
+}
+
+func TestCounterSendsDogStatsDLine(t *testing.T) {
+	conn, addr := newTestServer(t)
+	defer conn.Close()
+
+	f, err := NewMetricFactory(addr, "ct_hammer.")
+	if err != nil {
+		t.Fatalf("NewMetricFactory() = %v", err)
+	}
+	defer f.Close()
+
+	c := f.NewCounter("requests", "count of requests", "endpoint")
+	c.Inc("get-sth")
+
+	got := readPacket(t, conn)
+	want := "ct_hammer.requests:1|c|#endpoint:get-sth"
+	if got != want {
+		t.Errorf("got packet %q, want %q", got, want)
+	}
+	if got, want := c.Value("get-sth"), float64(1); got != want {
+		t.Errorf("Value() = %v, want %v", got, want)
+	}
+}
+
+func TestGaugeSetAndAdd(t *testing.T) {
+	conn, addr := newTestServer(t)
+	defer conn.Close()
+
+	f, err := NewMetricFactory(addr, "")
+	if err != nil {
+		t.Fatalf("NewMetricFactory() = %v", err)
+	}
+	defer f.Close()
+
+	g := f.NewGauge("inflight", "in-flight requests")
+	g.Set(5)
+	if got, want := readPacket(t, conn), "inflight:5|g"; got != want {
+		t.Errorf("got packet %q, want %q", got, want)
+	}
+
+	g.Add(2)
+	if got, want := readPacket(t, conn), "inflight:7|g"; got != want {
+		t.Errorf("got packet %q, want %q", got, want)
+	}
+	if got, want := g.Value(), float64(7); got != want {
+		t.Errorf("Value() = %v, want %v", got, want)
+	}
+}
+
+func TestHistogramObserve(t *testing.T) {
+	conn, addr := newTestServer(t)
+	defer conn.Close()
+
+	f, err := NewMetricFactory(addr, "")
+	if err != nil {
+		t.Fatalf("NewMetricFactory() = %v", err)
+	}
+	defer f.Close()
+
+	h := f.NewHistogram("latency_ms", "request latency")
+	h.Observe(12.5)
+	h.Observe(7.5)
+
+	// Both Observe calls land within one flushInterval, so they may be
+	// buffered into a single UDP packet joined by a newline.
+	got := readPacket(t, conn)
+	if !strings.HasPrefix(got, "latency_ms:12.5|h") {
+		t.Errorf("got packet %q, want prefix %q", got, "latency_ms:12.5|h")
+	}
+
+	if count, sum := h.Info(); count != 2 || sum != 20 {
+		t.Errorf("Info() = (%d, %v), want (2, 20)", count, sum)
+	}
+}
+
+func TestNewHistogramWithBucketsIgnoresBuckets(t *testing.T) {
+	conn, addr := newTestServer(t)
+	defer conn.Close()
+
+	f, err := NewMetricFactory(addr, "")
+	if err != nil {
+		t.Fatalf("NewMetricFactory() = %v", err)
+	}
+	defer f.Close()
+
+	h := f.NewHistogramWithBuckets("latency_ms", "request latency", []float64{1, 2, 5})
+	h.Observe(3)
+
+	got := readPacket(t, conn)
+	if !strings.HasPrefix(got, "latency_ms:3|h") {
+		t.Errorf("got packet %q, want prefix %q", got, "latency_ms:3|h")
+	}
+	if count, sum := h.Info(); count != 1 || sum != 3 {
+		t.Errorf("Info() = (%d, %v), want (1, 3)", count, sum)
+	}
+}
+
+func TestTagSuffix(t *testing.T) {
+	tests := []struct {
+		labelNames, labelvals []string
+		want                  string
+	}{
+		{nil, nil, ""},
+		{[]string{"endpoint"}, []string{"get-sth"}, "|#endpoint:get-sth"},
+		{[]string{"endpoint", "code"}, []string{"get-sth", "200"}, "|#endpoint:get-sth,code:200"},
+	}
+	for _, test := range tests {
+		if got := tagSuffix(test.labelNames, test.labelvals); got != test.want {
+			t.Errorf("tagSuffix(%v, %v) = %q, want %q", test.labelNames, test.labelvals, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/client"
+	"github.com/google/certificate-transparency-go/trillian/ctfe"
+	"github.com/google/certificate-transparency-go/trillian/ctfe/configpb"
+	"github.com/google/trillian/monitoring"
+)
+
+// opTimeout bounds how long a single hammer operation is allowed to take.
+const opTimeout = 30 * time.Second
+
+// HammerBias governs how operations are chosen and how often they're
+// deliberately malformed.
+type HammerBias struct {
+	// Bias gives the relative frequency of each operation; an entry point
+	// with a zero (or missing) bias is never chosen.
+	Bias map[ctfe.EntrypointName]int
+	// InvalidChance gives, for each entry point, a 1-in-N chance of the
+	// generated request being deliberately invalid (0 for never). Only
+	// add-chain and add-pre-chain requests are actually made invalid; for
+	// every other entry point the draw is made but has no effect.
+	InvalidChance map[ctfe.EntrypointName]int
+}
+
+// choose picks an entry point at random, weighted by Bias. Entry points are
+// walked in sorted order so that the choice depends only on rnd.
+func (hb HammerBias) choose(rnd *rand.Rand) ctfe.EntrypointName {
+	total := 0
+	eps := make([]string, 0, len(hb.Bias))
+	for ep, b := range hb.Bias {
+		total += b
+		eps = append(eps, string(ep))
+	}
+	if total == 0 {
+		return ctfe.GetSTHName
+	}
+	sort.Strings(eps)
+	pick := rnd.Intn(total)
+	for _, name := range eps {
+		ep := ctfe.EntrypointName(name)
+		b := hb.Bias[ep]
+		if pick < b {
+			return ep
+		}
+		pick -= b
+	}
+	return ctfe.GetSTHName
+}
+
+// invalid reports whether the operation about to be issued for ep should be
+// deliberately malformed, per InvalidChance.
+func (hb HammerBias) invalid(rnd *rand.Rand, ep ctfe.EntrypointName) bool {
+	chance := hb.InvalidChance[ep]
+	return chance > 0 && rnd.Intn(chance) == 0
+}
+
+// ChainGenerator produces certificate chains for add-chain/add-pre-chain
+// operations; valid is false to request a chain that's deliberately invalid,
+// to exercise a log's validation logic.
+type ChainGenerator interface {
+	Next(valid bool) ([]ct.ASN1Cert, error)
+}
+
+// Limiter paces the rate at which HammerCTLog issues operations against a
+// log.
+type Limiter interface {
+	Wait()
+}
+
+// ClientPool hands out a CT log client to issue each operation against, e.g.
+// rotating across several front-end addresses that serve the same log.
+type ClientPool struct {
+	mu      sync.Mutex
+	idx     int
+	clients []*client.LogClient
+}
+
+// next returns the next client in the pool, round-robin.
+func (p *ClientPool) next() *client.LogClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.clients[p.idx%len(p.clients)]
+	p.idx++
+	return c
+}
+
+// Stats is a concurrency-safe set of per-endpoint operation counts and
+// latency totals that HammerCTLog updates as it runs, letting a caller
+// observe live progress (e.g. to relay it to a distributed-run coordinator)
+// without waiting for the run to finish. A nil *Stats is valid and simply
+// discards everything recorded into it.
+type Stats struct {
+	mu        sync.Mutex
+	counts    map[string]int64
+	errors    map[string]int64
+	latencyMs map[string]float64
+}
+
+// NewStats returns an empty Stats.
+func NewStats() *Stats {
+	return &Stats{
+		counts:    make(map[string]int64),
+		errors:    make(map[string]int64),
+		latencyMs: make(map[string]float64),
+	}
+}
+
+func (s *Stats) record(endpoint string, latency time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[endpoint]++
+	s.latencyMs[endpoint] += float64(latency) / float64(time.Millisecond)
+	if err != nil {
+		s.errors[endpoint]++
+	}
+}
+
+// Snapshot returns a point-in-time copy of the accumulated counters, keyed
+// as "<endpoint>_count", "<endpoint>_errors" and "<endpoint>_latency_ms",
+// suitable for handing to something like coordinator.Client.ReportCounts.
+func (s *Stats) Snapshot() map[string]float64 {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]float64, 3*len(s.counts))
+	for ep, n := range s.counts {
+		out[ep+"_count"] = float64(n)
+		out[ep+"_latency_ms"] = s.latencyMs[ep]
+	}
+	for ep, n := range s.errors {
+		out[ep+"_errors"] = float64(n)
+	}
+	return out
+}
+
+// HammerConfig describes the parameters for hammering a single CT log.
+type HammerConfig struct {
+	// LogCfg is the configuration of the log to hammer.
+	LogCfg *configpb.LogConfig
+	// MetricFactory is used to build the per-endpoint counters and latency
+	// histograms exported while hammering.
+	MetricFactory monitoring.MetricFactory
+	// MMD is the log's maximum merge delay.
+	MMD time.Duration
+	// ChainGenerator generates chains for add-[pre-]chain operations.
+	ChainGenerator ChainGenerator
+	// ClientPool provides clients to issue requests against the log.
+	ClientPool *ClientPool
+	// EPBias governs how operations are selected and malformed.
+	EPBias HammerBias
+	// MinGetEntries and MaxGetEntries bound the size of get-entries requests.
+	MinGetEntries, MaxGetEntries int
+	// OversizedGetEntries allows get-entries requests to go beyond the
+	// current tree size.
+	OversizedGetEntries bool
+	// Operations is the number of operations to perform before returning;
+	// use ^uint64(0) to run until stopped.
+	Operations uint64
+	// Limiter paces the rate of operations, or is nil for no rate limit.
+	Limiter Limiter
+	// MaxParallelChains is the maximum number of chains to add in parallel.
+	MaxParallelChains int
+	// IgnoreErrors, if set, causes failed operations to be retried (up to
+	// MaxRetryDuration) rather than aborting the run.
+	IgnoreErrors bool
+	// MaxRetryDuration bounds how long to keep retrying an operation when
+	// IgnoreErrors is set.
+	MaxRetryDuration time.Duration
+	// WorkerID and WorkerCount identify this process's place in a fleet of
+	// coordinated hammer processes hammering the same log; WorkerCount is 1
+	// for a standalone run.
+	WorkerID, WorkerCount int
+	// Seed is the PRNG seed used to drive operation selection and request
+	// parameters, recorded in any ReportSink summary so a run can be
+	// reproduced. It is not passed to ChainGenerator.
+	Seed int64
+	// ReportSink, if set, is told about every operation performed and the
+	// final summary of the run, for structured reporting.
+	ReportSink ReportSink
+	// Stats, if set, is updated with live per-endpoint counts and latencies
+	// as the run progresses, e.g. so they can be relayed to a distributed
+	// run's coordinator on a timer.
+	Stats *Stats
+	// EntryRangeShard, if set, is consulted before each get-entries
+	// operation with the log's current tree size, and restricts the
+	// request to the returned [start, end) range; this is how a
+	// coordinated multi-worker run avoids every worker re-reading the same
+	// entries. It is nil for a standalone run.
+	EntryRangeShard func(treeSize int64) (start, end int64)
+}
+
+// endpointCounters holds the Prometheus/StatsD-style counters and latency
+// histogram exported for one log prefix while it's being hammered.
+type endpointCounters struct {
+	reqs      monitoring.Counter
+	errs      monitoring.Counter
+	latencyMs monitoring.Histogram
+}
+
+func newEndpointCounters(mf monitoring.MetricFactory, prefix string) *endpointCounters {
+	return &endpointCounters{
+		reqs:      mf.NewCounter("hammer_requests", "Number of hammer requests sent", "logprefix", "ep"),
+		errs:      mf.NewCounter("hammer_errors", "Number of hammer requests that failed", "logprefix", "ep"),
+		latencyMs: mf.NewHistogram("hammer_latency_ms", "Latency of hammer requests, in ms", "logprefix", "ep"),
+	}
+}
+
+func (c *endpointCounters) record(prefix string, ep ctfe.EntrypointName, latency time.Duration, err error) {
+	epName := string(ep)
+	c.reqs.Inc(prefix, epName)
+	c.latencyMs.Observe(float64(latency)/float64(time.Millisecond), prefix, epName)
+	if err != nil {
+		c.errs.Inc(prefix, epName)
+	}
+}
+
+// HammerCTLog hammers the log described by cfg.LogCfg until cfg.Operations
+// operations have completed, reporting progress via cfg.MetricFactory and,
+// if set, cfg.ReportSink and cfg.Stats.
+func HammerCTLog(cfg HammerConfig) error {
+	prefix := cfg.LogCfg.Prefix
+	startTime := time.Now()
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+	counters := newEndpointCounters(cfg.MetricFactory, prefix)
+	leaves := &recentLeaves{}
+
+	var lastSTH *STH
+	var errCount uint64
+	for i := uint64(0); i < cfg.Operations; i++ {
+		if cfg.Limiter != nil {
+			cfg.Limiter.Wait()
+		}
+
+		ep := cfg.EPBias.choose(rnd)
+		invalid := cfg.EPBias.invalid(rnd, ep)
+
+		opStart := time.Now()
+		var sth *STH
+		var params map[string]string
+		var err error
+		for {
+			attemptStart := time.Now()
+			sth, params, err = cfg.doOp(rnd, ep, invalid, lastSTH, leaves)
+			latency := time.Since(attemptStart)
+
+			counters.record(prefix, ep, latency, err)
+			cfg.Stats.record(string(ep), latency, err)
+			if cfg.ReportSink != nil {
+				cfg.ReportSink.RecordOp(prefix, string(ep), latency, err, params)
+			}
+
+			if err == nil || !cfg.IgnoreErrors || time.Since(opStart) >= cfg.MaxRetryDuration {
+				break
+			}
+			if cfg.ReportSink != nil {
+				cfg.ReportSink.RecordRetry(prefix, string(ep))
+			}
+			glog.Warningf("%s: retrying %s after error: %v", prefix, ep, err)
+		}
+
+		if err != nil {
+			errCount++
+			if !cfg.IgnoreErrors {
+				if cfg.ReportSink != nil {
+					cfg.finish(prefix, startTime, lastSTH)
+				}
+				return fmt.Errorf("%s: operation %s failed: %v", prefix, ep, err)
+			}
+			glog.Errorf("%s: %s failed: %v", prefix, ep, err)
+		}
+		if sth != nil {
+			lastSTH = sth
+		}
+	}
+
+	if cfg.ReportSink != nil {
+		if err := cfg.finish(prefix, startTime, lastSTH); err != nil {
+			glog.Warningf("%s: failed to write report: %v", prefix, err)
+		}
+	}
+	if errCount > 0 {
+		return fmt.Errorf("%s: %d operations failed", prefix, errCount)
+	}
+	return nil
+}
+
+// finish reports the summary of this run to cfg.ReportSink.
+func (cfg HammerConfig) finish(prefix string, startTime time.Time, sth *STH) error {
+	bias := make(map[string]int, len(cfg.EPBias.Bias))
+	for ep, b := range cfg.EPBias.Bias {
+		bias[string(ep)] = b
+	}
+	return cfg.ReportSink.Finish(prefix, LogRunSummary{
+		Seed:      cfg.Seed,
+		StartTime: startTime,
+		Bias:      bias,
+		STH:       sth,
+	})
+}
+
+// doOp issues the single operation for ep against the log, returning the
+// STH observed (if any), the request parameters (for failure reporting) and
+// any error.
+func (cfg HammerConfig) doOp(rnd *rand.Rand, ep ctfe.EntrypointName, invalid bool, lastSTH *STH, leaves *recentLeaves) (*STH, map[string]string, error) {
+	c := cfg.ClientPool.next()
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+
+	switch ep {
+	case ctfe.AddChainName, ctfe.AddPreChainName:
+		chain, err := cfg.ChainGenerator.Next(!invalid)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate chain: %v", err)
+		}
+		if ep == ctfe.AddChainName {
+			_, err = c.AddChain(ctx, chain)
+		} else {
+			_, err = c.AddPreChain(ctx, chain)
+		}
+		if err == nil && !invalid && len(chain) > 0 {
+			leaves.add(sha256.Sum256(chain[0].Data))
+		}
+		return nil, map[string]string{"chain_len": strconv.Itoa(len(chain)), "invalid": strconv.FormatBool(invalid)}, err
+
+	case ctfe.GetSTHName:
+		sth, err := c.GetSTH(ctx)
+		return sthToReportSTH(sth), nil, err
+
+	case ctfe.GetSTHConsistencyName:
+		first, second := consistencyRange(rnd, lastSTH)
+		_, err := c.GetSTHConsistency(ctx, first, second)
+		return nil, map[string]string{"first": strconv.FormatInt(first, 10), "second": strconv.FormatInt(second, 10)}, err
+
+	case ctfe.GetProofByHashName:
+		hash, fromLeaf := leaves.random(rnd)
+		if !fromLeaf {
+			hash = randomLeafHash(rnd)
+		}
+		_, err := c.GetProofByHash(ctx, hash, treeSizeOf(lastSTH))
+		return nil, map[string]string{"tree_size": strconv.FormatInt(treeSizeOf(lastSTH), 10), "from_submitted_leaf": strconv.FormatBool(fromLeaf)}, err
+
+	case ctfe.GetEntriesName:
+		start, end := cfg.entryRange(rnd, lastSTH)
+		_, err := c.GetEntries(ctx, start, end)
+		return nil, map[string]string{"start": strconv.FormatInt(start, 10), "end": strconv.FormatInt(end, 10)}, err
+
+	case ctfe.GetRootsName:
+		_, err := c.GetRoots(ctx)
+		return nil, nil, err
+
+	case ctfe.GetEntryAndProofName:
+		treeSize := treeSizeOf(lastSTH)
+		index := int64(0)
+		if treeSize > 0 {
+			index = rnd.Int63n(treeSize)
+		}
+		_, err := c.GetEntryAndProof(ctx, index, treeSize)
+		return nil, map[string]string{"index": strconv.FormatInt(index, 10)}, err
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported entry point %v", ep)
+	}
+}
+
+// randomLeafHash returns an arbitrary-looking leaf hash to drive
+// get-proof-by-hash requests when recentLeaves has nothing to offer yet
+// (e.g. at the very start of a run, or when add-[pre-]chain is disabled);
+// such requests are expected to come back not-found, which is harmless for
+// load-testing purposes but exercises the not-found path rather than a real
+// inclusion proof.
+func randomLeafHash(rnd *rand.Rand) []byte {
+	var b [32]byte
+	rnd.Read(b[:])
+	h := sha256.Sum256(b[:])
+	return h[:]
+}
+
+// recentLeafCap bounds how many recently-submitted leaf hashes recentLeaves
+// retains; older entries are evicted first.
+const recentLeafCap = 256
+
+// recentLeaves is a bounded, round-robin record of hashes of leaves this run
+// has itself successfully submitted via add-chain/add-pre-chain, so
+// get-proof-by-hash requests can be aimed at entries that plausibly exist in
+// the log rather than pure noise. Note this records sha256 of the raw leaf
+// certificate bytes, not the log's RFC 6962 Merkle leaf hash (which covers
+// the whole TimestampedEntry, including the SCT timestamp) - so even a
+// recorded hash isn't guaranteed to be found, but it's a far better bet than
+// an unrelated random one. It is not safe for concurrent use; each
+// HammerCTLog call owns its own.
+type recentLeaves struct {
+	hashes [][32]byte
+	next   int
+}
+
+// add records h, evicting the oldest entry once recentLeafCap is reached.
+func (r *recentLeaves) add(h [32]byte) {
+	if len(r.hashes) < recentLeafCap {
+		r.hashes = append(r.hashes, h)
+		return
+	}
+	r.hashes[r.next] = h
+	r.next = (r.next + 1) % recentLeafCap
+}
+
+// random returns a uniformly-random previously-added hash, or ok=false if
+// none have been recorded yet.
+func (r *recentLeaves) random(rnd *rand.Rand) (hash []byte, ok bool) {
+	if len(r.hashes) == 0 {
+		return nil, false
+	}
+	h := r.hashes[rnd.Intn(len(r.hashes))]
+	return h[:], true
+}
+
+// entryRange picks a get-entries request range, honoring cfg.Min/MaxGetEntries,
+// cfg.OversizedGetEntries and, for a coordinated run, cfg.EntryRangeShard.
+func (cfg HammerConfig) entryRange(rnd *rand.Rand, lastSTH *STH) (start, end int64) {
+	treeSize := treeSizeOf(lastSTH)
+	lo, hi := int64(0), treeSize
+	if cfg.EntryRangeShard != nil {
+		lo, hi = cfg.EntryRangeShard(treeSize)
+	}
+	if hi <= lo {
+		return lo, lo
+	}
+
+	count := cfg.MinGetEntries
+	if cfg.MaxGetEntries > cfg.MinGetEntries {
+		count += rnd.Intn(cfg.MaxGetEntries - cfg.MinGetEntries)
+	}
+	start = lo + rnd.Int63n(hi-lo)
+	end = start + int64(count)
+	// Only a range ending at the tree size may run past hi; a shard that
+	// ends earlier must not spill into the next worker's shard.
+	if end > hi && (!cfg.OversizedGetEntries || hi < treeSize) {
+		end = hi
+	}
+	return start, end
+}
+
+// sthToReportSTH converts a client-observed STH into the form recorded in a
+// ReportSink summary.
+func sthToReportSTH(sth *ct.SignedTreeHead) *STH {
+	if sth == nil {
+		return nil
+	}
+	return &STH{
+		TreeSize:  int64(sth.TreeSize),
+		RootHash:  hex.EncodeToString(sth.SHA256RootHash[:]),
+		Timestamp: int64(sth.Timestamp),
+	}
+}
+
+func treeSizeOf(sth *STH) int64 {
+	if sth == nil {
+		return 0
+	}
+	return sth.TreeSize
+}
+
+// consistencyRange picks a (first, second) pair of tree sizes to request
+// get-sth-consistency proof between.
+func consistencyRange(rnd *rand.Rand, lastSTH *STH) (first, second int64) {
+	treeSize := treeSizeOf(lastSTH)
+	if treeSize < 2 {
+		return 0, treeSize
+	}
+	first = rnd.Int63n(treeSize)
+	second = first + rnd.Int63n(treeSize-first)
+	return first, second
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import "time"
+
+// STH summarizes the final signed tree head observed for a log during a
+// HammerCTLog run.
+type STH struct {
+	TreeSize  int64
+	RootHash  string
+	Timestamp int64
+}
+
+// LogRunSummary is the portion of a HammerCTLog run's result that a
+// ReportSink can't derive from the individual RecordOp/RecordRetry calls it's
+// fed, supplied once the run for a log prefix is done.
+type LogRunSummary struct {
+	Seed      int64
+	StartTime time.Time
+	Bias      map[string]int
+	STH       *STH
+}
+
+// ReportSink receives structured telemetry for a HammerCTLog run so it can
+// be persisted however the caller likes (e.g. to an NDJSON file, or
+// discarded), without HammerCTLog itself needing to know whether or how a
+// report is produced. It lives in this package, rather than in a ct_hammer
+// subpackage, because HammerConfig (used by any caller of HammerCTLog, not
+// just the ct_hammer command) holds one.
+type ReportSink interface {
+	// RecordOp records the outcome of a single operation against endpoint
+	// for the log identified by prefix. err is nil on success; params
+	// describes the request and is only meaningful when err is non-nil.
+	RecordOp(prefix, endpoint string, latency time.Duration, err error, params map[string]string)
+	// RecordRetry records that an operation against endpoint was retried.
+	RecordRetry(prefix, endpoint string)
+	// Finish is called once, after the last operation for prefix, with the
+	// summary of the whole run.
+	Finish(prefix string, summary LogRunSummary) error
+	// Close flushes and releases any resources held by the sink.
+	Close() error
+}